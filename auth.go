@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"os/exec"
+	"strings"
+)
+
+// loginAuth implements the LOGIN SMTP authentication mechanism, which
+// net/smtp does not provide. It responds to the server's "Username:" and
+// "Password:" prompts in order, as required by Office365 and other hosts
+// that reject PLAIN over STARTTLS.
+type loginAuth struct {
+	username, password string
+}
+
+// LoginAuth returns an smtp.Auth implementing the LOGIN mechanism.
+func LoginAuth(username, password string) smtp.Auth {
+	return &loginAuth{username, password}
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.TrimSuffix(string(fromServer), ":") {
+	case "Username":
+		return []byte(a.username), nil
+	case "Password":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("loginAuth: unexpected server challenge %q", fromServer)
+	}
+}
+
+// xoauth2Auth implements the XOAUTH2 SMTP authentication mechanism used by
+// Gmail and Office365 in place of a password.
+type xoauth2Auth struct {
+	username, accessToken string
+}
+
+// XOAuth2Auth returns an smtp.Auth implementing XOAUTH2, authenticating
+// username with accessToken instead of a password.
+func XOAuth2Auth(username, accessToken string) smtp.Auth {
+	return &xoauth2Auth{username, accessToken}
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	resp := fmt.Sprintf("user=%s\x01auth=Bearer %s\x01\x01", a.username, a.accessToken)
+	return "XOAUTH2", []byte(resp), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// The server returned an error as a base64-encoded JSON challenge;
+		// respond with an empty message so it reports the failure cleanly.
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+// fetchXOAuth2Token runs config.TokenCommand and returns its trimmed
+// stdout as the access token to use for the next connection.
+func fetchXOAuth2Token(tokenCommand string) (string, error) {
+	if tokenCommand == "" {
+		return "", errors.New("xoauth2 auth requires tokencommand to be set")
+	}
+
+	out, err := exec.Command("sh", "-c", tokenCommand).Output()
+	if err != nil {
+		return "", fmt.Errorf("tokencommand failed: %w", err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// getAuth builds the smtp.Auth for config.Auth, defaulting to PLAIN for
+// backward compatibility with configs that don't set it. It returns an
+// error rather than dying outright on failure - dialSMTP is called on
+// every reconnect inside sendMail's retry loop and inside every pool
+// worker, so a transient TokenCommand failure must be classifiable as a
+// per-message/per-attempt error instead of killing the whole run.
+func getAuth(config *Config) (smtp.Auth, error) {
+	switch config.Auth {
+	case "", "plain":
+		return smtp.PlainAuth("", config.Username, config.Password, config.Host), nil
+	case "login":
+		return LoginAuth(config.Username, config.Password), nil
+	case "cram-md5":
+		return smtp.CRAMMD5Auth(config.Username, config.Password), nil
+	case "xoauth2":
+		token, err := fetchXOAuth2Token(config.TokenCommand)
+		if err != nil {
+			return nil, err
+		}
+		return XOAuth2Auth(config.Username, token), nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported auth type %q", config.Auth)
+	}
+}
+
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/smtp"
+	"testing"
+)
+
+func TestLoginAuthStart(t *testing.T) {
+	auth := LoginAuth("alice", "hunter2")
+
+	mech, resp, err := auth.Start(&smtp.ServerInfo{})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if mech != "LOGIN" {
+		t.Errorf("got mechanism %q, want LOGIN", mech)
+	}
+	if resp != nil {
+		t.Errorf("got initial response %q, want nil", resp)
+	}
+}
+
+func TestLoginAuthNext(t *testing.T) {
+	auth := LoginAuth("alice", "hunter2")
+
+	user, err := auth.Next([]byte("Username:"), true)
+	if err != nil {
+		t.Fatalf("Next(Username): %v", err)
+	}
+	if string(user) != "alice" {
+		t.Errorf("got %q, want %q", user, "alice")
+	}
+
+	pass, err := auth.Next([]byte("Password:"), true)
+	if err != nil {
+		t.Fatalf("Next(Password): %v", err)
+	}
+	if string(pass) != "hunter2" {
+		t.Errorf("got %q, want %q", pass, "hunter2")
+	}
+
+	if resp, err := auth.Next(nil, false); err != nil || resp != nil {
+		t.Errorf("Next(done) = %q, %v, want nil, nil", resp, err)
+	}
+}
+
+func TestLoginAuthNextUnexpectedChallenge(t *testing.T) {
+	auth := LoginAuth("alice", "hunter2")
+
+	if _, err := auth.Next([]byte("Realm:"), true); err == nil {
+		t.Fatal("expected an error for an unrecognized challenge")
+	}
+}
+
+func TestXOAuth2AuthStart(t *testing.T) {
+	auth := XOAuth2Auth("alice@example.com", "token123")
+
+	mech, resp, err := auth.Start(&smtp.ServerInfo{})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if mech != "XOAUTH2" {
+		t.Errorf("got mechanism %q, want XOAUTH2", mech)
+	}
+
+	want := "user=alice@example.com\x01auth=Bearer token123\x01\x01"
+	if string(resp) != want {
+		t.Errorf("got %q, want %q", resp, want)
+	}
+}
+
+func TestXOAuth2AuthNextOnError(t *testing.T) {
+	auth := XOAuth2Auth("alice@example.com", "token123")
+
+	resp, err := auth.Next([]byte(`{"status":"401"}`), true)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if len(resp) != 0 {
+		t.Errorf("got %q, want empty response", resp)
+	}
+}
+
+func TestFetchXOAuth2TokenRequiresCommand(t *testing.T) {
+	if _, err := fetchXOAuth2Token(""); err == nil {
+		t.Fatal("expected an error when tokencommand is unset")
+	}
+}
+
+func TestFetchXOAuth2TokenRunsCommand(t *testing.T) {
+	token, err := fetchXOAuth2Token("echo '  token-via-shell  '")
+	if err != nil {
+		t.Fatalf("fetchXOAuth2Token: %v", err)
+	}
+	if token != "token-via-shell" {
+		t.Errorf("got %q, want %q", token, "token-via-shell")
+	}
+}
+
+func TestGetAuthXOAuth2FailureIsAnError(t *testing.T) {
+	config := &Config{Auth: "xoauth2", TokenCommand: "exit 1"}
+
+	auth, err := getAuth(config)
+	if err == nil {
+		t.Fatal("expected an error when TokenCommand fails, not a Fatal exit")
+	}
+	if auth != nil {
+		t.Errorf("got non-nil auth alongside an error")
+	}
+}
+
+func TestGetAuthUnsupportedTypeIsAnError(t *testing.T) {
+	config := &Config{Auth: "kerberos"}
+
+	if _, err := getAuth(config); err == nil {
+		t.Fatal("expected an error for an unsupported auth type")
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// DKIMConfig configures optional DKIM signing of outgoing mail. Domain
+// being empty (the default) means DKIM signing is disabled. signer and
+// algorithm are populated once by loadDKIMConfig at config-load time, so
+// signDKIM never re-reads or re-parses the private key file per message.
+type DKIMConfig struct {
+	Domain           string   `json:"domain"`
+	Selector         string   `json:"selector"`
+	PrivateKeyPath   string   `json:"privatekeypath"`
+	Headers          []string `json:"headers"`
+	Canonicalization string   `json:"canonicalization"`
+
+	signer    crypto.Signer
+	algorithm string
+}
+
+// dkimDefaultHeaders lists the headers signed when DKIM.Headers is unset.
+var dkimDefaultHeaders = []string{"From", "To", "Subject", "Date"}
+
+// loadDKIMConfig reads and parses DKIM.PrivateKeyPath once, so sendMail's
+// per-message signDKIM call never touches disk. A no-op when DKIM.Domain
+// is unset. Canonicalization must be empty or "relaxed/relaxed"; any other
+// value is rejected.
+func loadDKIMConfig(dkim *DKIMConfig) error {
+	if dkim.Domain == "" {
+		return nil
+	}
+
+	if dkim.Canonicalization != "" && dkim.Canonicalization != "relaxed/relaxed" {
+		return fmt.Errorf("dkim: unsupported canonicalization %q (only relaxed/relaxed is implemented)", dkim.Canonicalization)
+	}
+
+	signer, algorithm, err := loadDKIMSigner(dkim.PrivateKeyPath)
+	if err != nil {
+		return fmt.Errorf("dkim: %w", err)
+	}
+
+	dkim.signer = signer
+	dkim.algorithm = algorithm
+	return nil
+}
+
+// signDKIM prepends a DKIM-Signature header to msg when config.DKIM is
+// enabled, using relaxed/relaxed canonicalization of the header fields in
+// config.DKIM.Headers and of the body; no other canonicalization is
+// implemented. msg is returned unchanged when DKIM.Domain is empty.
+func signDKIM(config *Config, msg []byte) ([]byte, error) {
+	dkim := config.DKIM
+	if dkim.Domain == "" {
+		return msg, nil
+	}
+
+	signer, algorithm := dkim.signer, dkim.algorithm
+
+	canon := dkim.Canonicalization
+	if canon == "" {
+		canon = "relaxed/relaxed"
+	}
+
+	headerBlock, body := splitMessage(msg)
+
+	headerNames := dkim.Headers
+	if len(headerNames) == 0 {
+		headerNames = dkimDefaultHeaders
+	}
+
+	bodyHash := sha256.Sum256(canonicalizeBodyRelaxed(body))
+	bh := base64.StdEncoding.EncodeToString(bodyHash[:])
+
+	dkimValue := fmt.Sprintf(
+		"v=1; a=%s; c=%s; d=%s; s=%s; h=%s; bh=%s; b=",
+		algorithm, canon, dkim.Domain, dkim.Selector, strings.Join(headerNames, ":"), bh,
+	)
+
+	signedData := canonicalizeHeadersRelaxed(headerBlock, headerNames)
+	signedData = append(signedData, []byte("dkim-signature:"+unfoldAndCompress(dkimValue))...)
+
+	signature, err := signDKIMData(signer, signedData)
+	if err != nil {
+		return nil, fmt.Errorf("dkim: %w", err)
+	}
+	dkimValue += base64.StdEncoding.EncodeToString(signature)
+
+	return append([]byte("DKIM-Signature: "+dkimValue+"\r\n"), msg...), nil
+}
+
+// loadDKIMSigner reads a PEM-encoded private key (PKCS#1 or PKCS#8,
+// RSA or Ed25519) and returns it alongside the DKIM "a=" algorithm name
+// it signs with.
+func loadDKIMSigner(path string) (crypto.Signer, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, "", fmt.Errorf("no PEM block found in %s", path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, "rsa-sha256", nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("unsupported private key in %s: %w", path, err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, "rsa-sha256", nil
+	case ed25519.PrivateKey:
+		return k, "ed25519-sha256", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported private key type %T in %s", key, path)
+	}
+}
+
+// signDKIMData signs data with signer, pre-hashing with SHA-256 for RSA
+// (Ed25519 hashes internally and must receive the raw message).
+func signDKIMData(signer crypto.Signer, data []byte) ([]byte, error) {
+	if _, ok := signer.(ed25519.PrivateKey); ok {
+		return signer.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+
+	digest := sha256.Sum256(data)
+	return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// splitMessage separates the header block from the body at the first
+// blank line, as RFC 5322 requires.
+func splitMessage(msg []byte) (headerBlock, body []byte) {
+	if idx := bytes.Index(msg, []byte("\r\n\r\n")); idx != -1 {
+		return msg[:idx], msg[idx+4:]
+	}
+	return msg, nil
+}
+
+// parseHeaderFields splits a header block into its individual fields,
+// rejoining folded continuation lines (those starting with whitespace)
+// onto the field they belong to.
+func parseHeaderFields(headerBlock []byte) []string {
+	var fields []string
+	for _, line := range strings.Split(string(headerBlock), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(fields) > 0 {
+			fields[len(fields)-1] += "\r\n" + line
+		} else {
+			fields = append(fields, line)
+		}
+	}
+	return fields
+}
+
+// canonicalizeHeadersRelaxed canonicalizes (RFC 6376 3.4.2) the named
+// header fields, in the order names lists them, using the last matching
+// occurrence of each - so the DKIM-Signature "h=" list controls both
+// which headers are covered and the order they're hashed in.
+func canonicalizeHeadersRelaxed(headerBlock []byte, names []string) []byte {
+	fields := parseHeaderFields(headerBlock)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		for i := len(fields) - 1; i >= 0; i-- {
+			colon := strings.Index(fields[i], ":")
+			if colon == -1 {
+				continue
+			}
+			if strings.EqualFold(strings.TrimSpace(fields[i][:colon]), name) {
+				buf.Write(canonicalizeHeaderField(fields[i]))
+				break
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// canonicalizeHeaderField relaxed-canonicalizes a single header field:
+// lowercased name, unfolded and whitespace-compressed value, CRLF
+// terminated.
+func canonicalizeHeaderField(field string) []byte {
+	colon := strings.Index(field, ":")
+	name := strings.ToLower(strings.TrimSpace(field[:colon]))
+	value := unfoldAndCompress(field[colon+1:])
+	return []byte(name + ":" + value + "\r\n")
+}
+
+// unfoldAndCompress removes header folding and collapses runs of
+// whitespace to a single space, trimming the ends - the value half of
+// relaxed header canonicalization.
+func unfoldAndCompress(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// canonicalizeBodyRelaxed relaxed-canonicalizes (RFC 6376 3.4.3) a
+// message body: trailing whitespace removed from every line, runs of
+// spaces/tabs within a line collapsed to one, and any trailing empty
+// lines dropped (leaving a single trailing CRLF for a non-empty body).
+func canonicalizeBodyRelaxed(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(compressWSP(line), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// compressWSP collapses runs of spaces and tabs in line to a single space.
+func compressWSP(line string) string {
+	var buf strings.Builder
+	wasWSP := false
+	for _, r := range line {
+		if r == ' ' || r == '\t' {
+			if !wasWSP {
+				buf.WriteRune(' ')
+			}
+			wasWSP = true
+			continue
+		}
+		buf.WriteRune(r)
+		wasWSP = false
+	}
+	return buf.String()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalizeHeaderField(t *testing.T) {
+	got := string(canonicalizeHeaderField("Subject:  Hello   \r\n   World  "))
+	want := "subject:Hello World\r\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCanonicalizeHeadersRelaxedUsesLastOccurrenceInOrder(t *testing.T) {
+	headerBlock := []byte("From: first@example.com\r\nFrom: second@example.com\r\nSubject: Hi\r\n")
+
+	got := string(canonicalizeHeadersRelaxed(headerBlock, []string{"subject", "from"}))
+	want := "subject:Hi\r\nfrom:second@example.com\r\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCanonicalizeBodyRelaxedDropsTrailingBlankLines(t *testing.T) {
+	got := string(canonicalizeBodyRelaxed([]byte("Hello   World \r\n \r\n\r\n")))
+	want := "Hello World\r\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCanonicalizeBodyRelaxedEmptyBody(t *testing.T) {
+	got := canonicalizeBodyRelaxed(nil)
+	if len(got) != 0 {
+		t.Errorf("got %q, want empty", got)
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	headers, body := splitMessage([]byte("From: a@b.com\r\nTo: c@d.com\r\n\r\nhello\r\n"))
+	if string(headers) != "From: a@b.com\r\nTo: c@d.com" {
+		t.Errorf("unexpected headers: %q", headers)
+	}
+	if string(body) != "hello\r\n" {
+		t.Errorf("unexpected body: %q", body)
+	}
+}
+
+func TestSignDKIMPrependsHeaderAndIsVerifiable(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	keyPath := writeTempPEM(t, pkcs8)
+
+	config := &Config{DKIM: DKIMConfig{
+		Domain:         "example.com",
+		Selector:       "s1",
+		PrivateKeyPath: keyPath,
+	}}
+	if err := loadDKIMConfig(&config.DKIM); err != nil {
+		t.Fatalf("loadDKIMConfig: %v", err)
+	}
+
+	msg := []byte("From: a@example.com\r\nTo: b@example.com\r\nSubject: Hi\r\nDate: Mon, 02 Jan 2006 15:04:05 +0000\r\n\r\nHello\r\n")
+
+	signed, err := signDKIM(config, msg)
+	if err != nil {
+		t.Fatalf("signDKIM: %v", err)
+	}
+	if !strings.HasPrefix(string(signed), "DKIM-Signature: v=1; a=ed25519-sha256;") {
+		t.Fatalf("DKIM-Signature header missing or malformed: %q", signed[:80])
+	}
+
+	headerBlock, _ := splitMessage(signed)
+	fields := parseHeaderFields(headerBlock)
+	dkimLine := fields[0]
+
+	bTag := strings.LastIndex(dkimLine, "; b=")
+	if bTag == -1 {
+		t.Fatalf("no b= tag found in %q", dkimLine)
+	}
+	bValueStart := bTag + len("; b=")
+
+	signedData := canonicalizeHeadersRelaxed(headerBlock, dkimDefaultHeaders)
+	signedData = append(signedData, []byte("dkim-signature:"+unfoldAndCompress(dkimLine[strings.Index(dkimLine, ":")+1:bValueStart]))...)
+
+	sigB64 := dkimLine[bValueStart:]
+	sig, err := base64.StdEncoding.DecodeString(sigB64)
+	if err != nil {
+		t.Fatalf("decode signature: %v", err)
+	}
+	if !ed25519.Verify(pub, signedData, sig) {
+		t.Fatal("DKIM signature does not verify against the canonicalized signed data")
+	}
+}
+
+// writeTempPEM PEM-encodes der as a PRIVATE KEY block in a temp file and
+// returns its path.
+func writeTempPEM(t *testing.T, der []byte) string {
+	t.Helper()
+
+	f, err := os.CreateTemp(t.TempDir(), "dkim-*.pem")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	block := &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	if err := pem.Encode(f, block); err != nil {
+		t.Fatalf("pem.Encode: %v", err)
+	}
+	f.Close()
+	return f.Name()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	netmail "net/mail"
+	"strings"
+)
+
+// stripCRLF removes carriage returns and line feeds from s. Header field
+// values are written into the message as a single line, so letting a CR
+// or LF from a recipient's Email/Realname or a task's Sender/ReplyTo
+// through would let it inject arbitrary extra headers.
+func stripCRLF(s string) string {
+	s = strings.ReplaceAll(s, "\r", "")
+	return strings.ReplaceAll(s, "\n", "")
+}
+
+// formatHeaderAddress renders a single address for an RFC 5322 header,
+// quoting and RFC 2047-encoding any display name. addr may already be in
+// "Name <user@host>" form (e.g. hand-written in a task file); malformed
+// input is passed through unchanged (but always CRLF-stripped) rather
+// than dropped.
+func formatHeaderAddress(addr string) string {
+	addr = stripCRLF(addr)
+
+	parsed, err := netmail.ParseAddress(addr)
+	if err != nil {
+		return addr
+	}
+	return parsed.String()
+}
+
+// joinAddresses formats each address and comma-separates them, as RFC
+// 5322 requires (a single "To: a;b;c" header is not valid SMTP).
+func joinAddresses(addrs []string) string {
+	formatted := make([]string, len(addrs))
+	for i, addr := range addrs {
+		formatted[i] = formatHeaderAddress(addr)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// isASCII reports whether s contains only 7-bit ASCII bytes.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] > 127 {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeHeaderText RFC 2047-encodes s (Q-encoding) when it contains
+// non-ASCII characters, leaving plain ASCII text untouched. CR/LF are
+// stripped first for the same header-injection reason as formatHeaderAddress.
+func encodeHeaderText(s string) string {
+	s = stripCRLF(s)
+	if isASCII(s) {
+		return s
+	}
+	return mime.QEncoding.Encode("utf-8", s)
+}
+
+// writeListUnsubscribeHeaders emits List-Unsubscribe and, when a URL is
+// present, the one-click List-Unsubscribe-Post header Gmail and Yahoo
+// require of bulk senders.
+func writeListUnsubscribeHeaders(buf *bytes.Buffer, mail Mail) {
+	var targets []string
+	if mail.ListUnsubscribeMailto != "" {
+		targets = append(targets, fmt.Sprintf("<mailto:%s>", mail.ListUnsubscribeMailto))
+	}
+	if mail.ListUnsubscribeURL != "" {
+		targets = append(targets, fmt.Sprintf("<%s>", mail.ListUnsubscribeURL))
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	buf.WriteString(fmt.Sprintf("List-Unsubscribe: %s\r\n", strings.Join(targets, ", ")))
+	if mail.ListUnsubscribeURL != "" {
+		buf.WriteString("List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatHeaderAddressStripsInjectedHeaders(t *testing.T) {
+	got := formatHeaderAddress("victim@example.com\r\nBcc: attacker@evil.com")
+	if strings.ContainsAny(got, "\r\n") {
+		t.Fatalf("formatHeaderAddress let a CR/LF through: %q", got)
+	}
+}
+
+func TestJoinAddressesStripsInjectedHeaders(t *testing.T) {
+	got := joinAddresses([]string{"victim@example.com\r\nBcc: attacker@evil.com"})
+	if strings.ContainsAny(got, "\r\n") {
+		t.Fatalf("joinAddresses let a CR/LF through: %q", got)
+	}
+}
+
+func TestEncodeHeaderTextStripsInjectedHeaders(t *testing.T) {
+	got := encodeHeaderText("Hello\r\nBcc: attacker@evil.com")
+	if strings.ContainsAny(got, "\r\n") {
+		t.Fatalf("encodeHeaderText let a CR/LF through: %q", got)
+	}
+}
+
+func TestFormatHeaderAddressQuotesDisplayName(t *testing.T) {
+	got := formatHeaderAddress("Ada Lovelace <ada@example.com>")
+	want := `"Ada Lovelace" <ada@example.com>`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
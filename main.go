@@ -7,14 +7,18 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	htmlpkg "html"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime"
 	"mime/multipart"
+	netmail "net/mail"
 	"net/smtp"
 	"net/textproto"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 	"unicode"
@@ -34,6 +38,30 @@ type Config struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	Type     string `json:"type"`
+
+	// Auth selects the SMTP authentication mechanism: "plain" (default),
+	// "login", "cram-md5", "xoauth2" or "none".
+	Auth string `json:"auth"`
+
+	// TokenCommand, when Auth is "xoauth2", is executed before each
+	// connection to fetch a fresh OAuth2 access token from stdout - so
+	// long runs don't die when a cached token expires.
+	TokenCommand string `json:"tokencommand"`
+
+	// MaxRetries caps how many times a 4xx (retryable) SMTP error is
+	// retried, with exponential backoff, before the recipient is marked
+	// failed. Defaults to 3.
+	MaxRetries int `json:"maxretries"`
+
+	// Workers is how many SMTP connections send concurrently. Defaults to
+	// 1, which keeps the original one-at-a-time, cooldown-throttled
+	// behavior unchanged.
+	Workers int `json:"workers"`
+
+	// DKIM, when its Domain is set, signs every outgoing message with the
+	// given domain/selector/private key before it's handed to the SMTP
+	// server.
+	DKIM DKIMConfig `json:"dkim"`
 }
 
 type Mail struct {
@@ -44,27 +72,55 @@ type Mail struct {
 	ReplyTo     string
 	Subject     string
 	Text        string
+	HTML        string
 	Charset     string
 	Attachments []Attachment
+
+	// EMLPath, if set, selects buildMessageFromEML instead of the
+	// template-based path: the message body is read from a prebuilt .eml
+	// file and Recipient is used to substitute variables into it.
+	EMLPath   string
+	Recipient Recipient
+
+	// MessageID is this mail's unique Message-ID (without the surrounding
+	// angle brackets), generated once so the header and the send log
+	// agree on it.
+	MessageID string
+
+	// ListUnsubscribeURL and ListUnsubscribeMailto populate the
+	// List-Unsubscribe header (and, with ListUnsubscribeURL, the
+	// one-click List-Unsubscribe-Post header Gmail/Yahoo require).
+	ListUnsubscribeURL    string
+	ListUnsubscribeMailto string
+
+	// PrimaryRecipient is the bare address of the main ("To") recipient,
+	// used as the send log key - unlike To, it's never display-name
+	// decorated, so resuming a run matches regardless of Realname.
+	PrimaryRecipient string
 }
 
 type Attachment struct {
 	Path    string `json:"path"`
 	Type    string `json:"type"`
 	Charset string `json:"charset"`
-}
+	Inline  bool   `json:"inline"`
+	CID     string `json:"cid"`
 
-type Variable struct {
-	Name  string `json:"name"`
-	Value string `json:"value"`
+	// Filename, if set, is a text/template source rendered per-recipient
+	// to produce the attachment's display filename, instead of the base
+	// name of Path.
+	Filename string `json:"filename"`
 }
 
+// Recipient is exposed to message templates as "." - so a template can
+// write {{.Realname}}, {{.Email}}, or {{.Variables.plan}} to read a
+// per-recipient variable.
 type Recipient struct {
-	Realname    string       `json:"realname"`
-	Email       string       `json:"email"`
-	Url         string       `json:"url"`
-	Attachments []Attachment `json:"attachments"`
-	Variables   []Variable   `json:"variables"`
+	Realname    string            `json:"realname"`
+	Email       string            `json:"email"`
+	Url         string            `json:"url"`
+	Attachments []Attachment      `json:"attachments"`
+	Variables   map[string]string `json:"variables"`
 }
 
 type Task struct {
@@ -75,11 +131,24 @@ type Task struct {
 	Cc            []string     `json:"cc"`
 	Bcc           []string     `json:"bcc"`
 	Bodytemplate  string       `json:"bodytemplate"`
+	HTMLTemplate  string       `json:"htmltemplate"`
+	EMLTemplate   string       `json:"emltemplate"`
 	Charset       string       `json:"charset"`
 	Recipientfile string       `json:"recipientfile"`
 	Attachments   []Attachment `json:"attachments"`
 	Cooldown      int          `json:"cooldown"`
 	Countdown     int          `json:"safetycountdown"`
+
+	// MaxPerSecond and MaxPerHour rate-limit sending when Config.Workers
+	// > 1; zero means unlimited. Each worker waits on the same limiters
+	// before sending, so the cap holds regardless of worker count.
+	MaxPerSecond float64 `json:"maxpersecond"`
+	MaxPerHour   float64 `json:"maxperhour"`
+
+	// ListUnsubscribeURL and ListUnsubscribeMailto add the
+	// List-Unsubscribe header bulk senders need for Gmail/Yahoo inboxing.
+	ListUnsubscribeURL    string `json:"listunsubscribeurl"`
+	ListUnsubscribeMailto string `json:"listunsubscribemailto"`
 }
 
 var (
@@ -94,6 +163,7 @@ var (
 	outputDir      = ""
 	dryRun         = false
 	printVersion   = false
+	resume         = false
 
 	countdown = 30
 	cooldown  = 30
@@ -105,6 +175,7 @@ func init() {
 	flag.StringVar(&outputDir, "output", "", "output directory for storing .eml files")
 	flag.BoolVar(&dryRun, "dryrun", false, "do not actually send mails")
 	flag.BoolVar(&printVersion, "v", false, "print version and exit")
+	flag.BoolVar(&resume, "resume", false, "skip recipients already marked as sent in the send log")
 
 	file, err := os.OpenFile(Name+".log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
 	if err != nil {
@@ -139,6 +210,18 @@ func getSmtpConfig(jsonFile *string) *Config {
 		ErrorLogger.Fatal("required fields for config: hostname, port, username, password, type")
 	}
 
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 3
+	}
+
+	if config.Workers == 0 {
+		config.Workers = 1
+	}
+
+	if err := loadDKIMConfig(&config.DKIM); err != nil {
+		ErrorLogger.Fatal(err)
+	}
+
 	DebugLogger.Printf(
 		"Loaded SMTP config for \"%s@%s:%d\"\n",
 		config.Username,
@@ -163,13 +246,16 @@ func getTask(jsonFile *string) *Task {
 		ErrorLogger.Fatal(err)
 	}
 
-	if task.Name == "" || task.Sender == "" || task.Subject == "" || task.Recipientfile == "" ||
-		task.Bodytemplate == "" {
+	if task.Name == "" || task.Sender == "" || task.Subject == "" || task.Recipientfile == "" {
 		ErrorLogger.Fatal(
-			"required fields for task: name, sender, username, recipientfile, bodytemplate",
+			"required fields for task: name, sender, subject, recipientfile",
 		)
 	}
 
+	if task.Bodytemplate == "" && task.HTMLTemplate == "" && task.EMLTemplate == "" {
+		ErrorLogger.Fatal("task must specify at least one of bodytemplate, htmltemplate, emltemplate")
+	}
+
 	if task.Cooldown != 0 {
 		if dryRun {
 			WarningLogger.Println("task-specific cooldown not active when -dryrun is specified")
@@ -191,7 +277,15 @@ func getTask(jsonFile *string) *Task {
 	}
 
 	task.Recipientfile = adjustFilePath(jsonFile, &task.Recipientfile)
-	task.Bodytemplate = adjustFilePath(jsonFile, &task.Bodytemplate)
+	if task.Bodytemplate != "" {
+		task.Bodytemplate = adjustFilePath(jsonFile, &task.Bodytemplate)
+	}
+	if task.HTMLTemplate != "" {
+		task.HTMLTemplate = adjustFilePath(jsonFile, &task.HTMLTemplate)
+	}
+	if task.EMLTemplate != "" {
+		task.EMLTemplate = adjustFilePath(jsonFile, &task.EMLTemplate)
+	}
 
 	// adjust file paths
 	attachments := task.Attachments
@@ -270,14 +364,82 @@ func normalizeFileName(fileName string) string {
 	return normalizedFileName
 }
 
+// htmlTagPattern matches tags for the crude HTML->text fallback used when a
+// task only supplies an HTML template.
+var (
+	htmlTagPattern    = regexp.MustCompile(`(?s)<[^>]*>`)
+	htmlScriptPattern = regexp.MustCompile(`(?is)<script[^>]*>.*?</script>`)
+	htmlStylePattern  = regexp.MustCompile(`(?is)<style[^>]*>.*?</style>`)
+)
+
+// htmlToText strips tags from html and unescapes entities, producing a
+// plain-text alternative good enough for MUAs that don't render HTML.
+// <script> and <style> elements are dropped wholesale first, since their
+// contents aren't text either tag-stripping or unescaping should surface.
+func htmlToText(html string) string {
+	html = htmlScriptPattern.ReplaceAllString(html, "")
+	html = htmlStylePattern.ReplaceAllString(html, "")
+
+	text := htmlTagPattern.ReplaceAllString(html, "")
+	text = htmlpkg.UnescapeString(text)
+	text = regexp.MustCompile(`[ \t]*\n[ \t]*`).ReplaceAllString(text, "\n")
+	return strings.TrimSpace(text)
+}
+
+// writeAttachmentPart writes a single attachment as a base64-encoded MIME
+// part, using Content-ID and "inline" disposition for attachments marked
+// Inline so they can be referenced from HTML bodies via cid: URLs.
+func writeAttachmentPart(mw *multipart.Writer, attachment Attachment) {
+	displayName := attachment.Filename
+	if displayName == "" {
+		displayName = filepath.Base(attachment.Path)
+	}
+	fileName := normalizeFileName(displayName)
+
+	header := textproto.MIMEHeader{
+		"Content-Type": {
+			fmt.Sprintf("%s; charset=\"%s\"", attachment.Type, attachment.Charset),
+		},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+
+	if attachment.Inline {
+		header.Set("Content-ID", fmt.Sprintf("<%s>", attachment.CID))
+		header.Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", fileName))
+	} else {
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
+	}
+
+	pw, err := mw.CreatePart(header)
+	if err != nil {
+		ErrorLogger.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(attachment.Path)
+	if err != nil {
+		ErrorLogger.Fatal(err)
+	}
+
+	pw.Write([]byte(base64.StdEncoding.EncodeToString(data)))
+}
+
+// buildMessage dispatches to the EML or template code path depending on
+// whether the task configured an EMLTemplate.
 func buildMessage(mail Mail) []byte {
+	if mail.EMLPath != "" {
+		return buildMessageFromEML(mail)
+	}
+	return buildMessageFromTemplate(mail)
+}
+
+func buildMessageFromTemplate(mail Mail) []byte {
 	var buf bytes.Buffer
 
 	// From:
-	buf.WriteString(fmt.Sprintf("From: %s\r\n", mail.Sender))
+	buf.WriteString(fmt.Sprintf("From: %s\r\n", formatHeaderAddress(mail.Sender)))
 
 	if mail.ReplyTo != "" {
-		buf.WriteString(fmt.Sprintf("Reply-To: %s\r\n", mail.ReplyTo))
+		buf.WriteString(fmt.Sprintf("Reply-To: %s\r\n", formatHeaderAddress(mail.ReplyTo)))
 	}
 
 	// Date:
@@ -286,33 +448,50 @@ func buildMessage(mail Mail) []byte {
 
 	// To:
 	if len(mail.To) > 0 {
-		buf.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(mail.To, ";")))
+		buf.WriteString(fmt.Sprintf("To: %s\r\n", joinAddresses(mail.To)))
 	}
 
 	// Cc:
 	if len(mail.Cc) > 0 {
-		buf.WriteString(fmt.Sprintf("Cc: %s\r\n", strings.Join(mail.Cc, ";")))
+		buf.WriteString(fmt.Sprintf("Cc: %s\r\n", joinAddresses(mail.Cc)))
 	}
 
 	// Subject:
-	buf.WriteString(fmt.Sprintf("Subject: %s\r\n", mail.Subject))
+	buf.WriteString(fmt.Sprintf("Subject: %s\r\n", encodeHeaderText(mail.Subject)))
 
-	// Multipart header
-	mw := multipart.NewWriter(&buf)
-	contentType := "multipart/mixed"
-	charset := "utf-8"
-	boundary := mw.Boundary()
+	// Message-ID:
+	buf.WriteString(fmt.Sprintf("Message-ID: <%s>\r\n", mail.MessageID))
 
+	writeListUnsubscribeHeaders(&buf, mail)
+
+	charset := "utf-8"
 	if mail.Charset != "" {
 		charset = mail.Charset
 	}
 
+	htmlBody := mail.HTML
+	if htmlBody != "" && mail.Text == "" {
+		mail.Text = htmlToText(htmlBody)
+	}
+
+	var inlineAttachments, regularAttachments []Attachment
+	for i := range mail.Attachments {
+		if mail.Attachments[i].Inline {
+			inlineAttachments = append(inlineAttachments, mail.Attachments[i])
+		} else {
+			regularAttachments = append(regularAttachments, mail.Attachments[i])
+		}
+	}
+
+	// Outermost multipart/mixed header
+	mw := multipart.NewWriter(&buf)
+	boundary := mw.Boundary()
+
 	buf.WriteString("MIME-Version: 1.0\r\n")
 	buf.WriteString(fmt.Sprintf("User-Agent: %s\r\n", UserAgent))
 	buf.WriteString(
 		fmt.Sprintf(
-			"Content-Type: %s; charset=\"%s\"; boundary=\"%s\"\r\n",
-			contentType,
+			"Content-Type: multipart/mixed; charset=\"%s\"; boundary=\"%s\"\r\n",
 			charset,
 			boundary,
 		),
@@ -320,46 +499,80 @@ func buildMessage(mail Mail) []byte {
 	buf.WriteString(fmt.Sprintf("Content-Disposition: %s\r\n", "inline"))
 	buf.WriteString("\r\n")
 
-	// Part of inline body
-	pw, err := mw.CreatePart(textproto.MIMEHeader{
-		"Content-Type":        {fmt.Sprintf("text/plain; charset=\"%s\"", charset)},
-		"Content-Disposition": {"inline"},
-	})
-
-	if err != nil {
-		ErrorLogger.Fatal(err)
-	}
-
-	fmt.Fprint(pw, mail.Text)
-
-	// Remaining parts
-	for i := range mail.Attachments {
-		attachment := mail.Attachments[i]
-
-		// adjust filenames to be SMTP-friendly
-		fileName := normalizeFileName(filepath.Base(attachment.Path))
-
+	if htmlBody == "" {
+		// Backward-compatible single text/plain body.
 		pw, err := mw.CreatePart(textproto.MIMEHeader{
-			"Content-Type": {
-				fmt.Sprintf("%s; charset=\"%s\"", attachment.Type, attachment.Charset),
-			},
-			"Content-Transfer-Encoding": {"base64"},
-			"Content-Disposition": {
-				fmt.Sprintf("attachment; filename=\"%s\"", fileName),
-			},
+			"Content-Type":        {fmt.Sprintf("text/plain; charset=\"%s\"", charset)},
+			"Content-Disposition": {"inline"},
 		})
+		if err != nil {
+			ErrorLogger.Fatal(err)
+		}
+		fmt.Fprint(pw, mail.Text)
+	} else {
+		// multipart/related wraps multipart/alternative when there are inline
+		// images, so cid: references in the HTML part can resolve.
+		var relatedWriter *multipart.Writer
+		bodyWriter := mw
+		if len(inlineAttachments) > 0 {
+			relatedBoundary := multipart.NewWriter(nil).Boundary()
+			relatedPart, err := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type": {
+					fmt.Sprintf("multipart/related; boundary=\"%s\"", relatedBoundary),
+				},
+			})
+			if err != nil {
+				ErrorLogger.Fatal(err)
+			}
+			relatedWriter = multipart.NewWriter(relatedPart)
+			if err := relatedWriter.SetBoundary(relatedBoundary); err != nil {
+				ErrorLogger.Fatal(err)
+			}
+			bodyWriter = relatedWriter
+		}
 
+		altBoundary := multipart.NewWriter(nil).Boundary()
+		altPart, err := bodyWriter.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {fmt.Sprintf("multipart/alternative; boundary=\"%s\"", altBoundary)},
+		})
 		if err != nil {
 			ErrorLogger.Fatal(err)
 		}
+		altWriter := multipart.NewWriter(altPart)
+		if err := altWriter.SetBoundary(altBoundary); err != nil {
+			ErrorLogger.Fatal(err)
+		}
 
-		data, err := ioutil.ReadFile(attachment.Path)
+		textPart, err := altWriter.CreatePart(textproto.MIMEHeader{
+			"Content-Type":        {fmt.Sprintf("text/plain; charset=\"%s\"", charset)},
+			"Content-Disposition": {"inline"},
+		})
+		if err != nil {
+			ErrorLogger.Fatal(err)
+		}
+		fmt.Fprint(textPart, mail.Text)
 
+		htmlPart, err := altWriter.CreatePart(textproto.MIMEHeader{
+			"Content-Type":        {fmt.Sprintf("text/html; charset=\"%s\"", charset)},
+			"Content-Disposition": {"inline"},
+		})
 		if err != nil {
 			ErrorLogger.Fatal(err)
 		}
+		fmt.Fprint(htmlPart, htmlBody)
+		altWriter.Close()
+
+		if relatedWriter != nil {
+			for i := range inlineAttachments {
+				writeAttachmentPart(relatedWriter, inlineAttachments[i])
+			}
+			relatedWriter.Close()
+		}
+	}
 
-		pw.Write([]byte(fmt.Sprint(base64.StdEncoding.EncodeToString(data))))
+	// Remaining (non-inline) attachments
+	for i := range regularAttachments {
+		writeAttachmentPart(mw, regularAttachments[i])
 	}
 
 	mw.Close()
@@ -367,103 +580,358 @@ func buildMessage(mail Mail) []byte {
 	return buf.Bytes()
 }
 
-func sendMail(config *Config, mail Mail, outputFile string, dryrun bool) {
-	msg := buildMessage(mail)
-
-	// Use [To...,Cc...,Bcc...] as RCPT TO, difference is resembled in mail header
-	var allRecipients = append(append(append([]string{}, mail.To...), mail.Cc...), mail.Bcc...)
-
-	if len(allRecipients) == 0 {
-		ErrorLogger.Fatal("the must be at least one recipient in To, Cc, or Bcc!")
+// rewriteMIMEPart copies a single MIME part (recursing into nested
+// multiparts) into w, substituting recipient variables into any text/plain
+// or text/html leaf and copying everything else - attachments, inline
+// images - unchanged.
+func rewriteMIMEPart(w *multipart.Writer, header textproto.MIMEHeader, body []byte, recipient Recipient) {
+	pw, err := w.CreatePart(header)
+	if err != nil {
+		ErrorLogger.Fatal(err)
 	}
 
-	var rcptTo string = strings.Join(allRecipients, ",")
+	mediaType, params, parseErr := mime.ParseMediaType(header.Get("Content-Type"))
 
-	// store mail to output directory
-	if outputFile != "" {
-		i := 0
-		// make sure nothing is overwritten in target destination
-		for _, err := os.Stat(outputFile); err == nil; i++ {
-			outputFile = filepath.Join(filepath.Dir(outputFile), fmt.Sprintf("%d.eml", i))
-			_, err = os.Stat(outputFile)
+	if parseErr == nil && strings.HasPrefix(mediaType, "multipart/") {
+		nested := multipart.NewWriter(pw)
+		if err := nested.SetBoundary(params["boundary"]); err != nil {
+			ErrorLogger.Fatal(err)
 		}
-		eml, err := os.Create(outputFile)
+
+		mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				ErrorLogger.Fatal(err)
+			}
+			partBody, err := ioutil.ReadAll(part)
+			if err != nil {
+				ErrorLogger.Fatal(err)
+			}
+			rewriteMIMEPart(nested, textproto.MIMEHeader(part.Header), partBody, recipient)
+		}
+		nested.Close()
+		return
+	}
+
+	if parseErr == nil && (mediaType == "text/plain" || mediaType == "text/html") {
+		rendered, err := renderByMediaType(mediaType, string(body), recipient)
 		if err != nil {
 			ErrorLogger.Fatal(err)
 		}
-		eml.Write(msg)
-		eml.Close()
+		pw.Write([]byte(rendered))
+		return
 	}
 
-	if dryrun {
-		fmt.Println(string(msg))
-		DebugLogger.Printf("dryrun: not sending mail to %s", rcptTo)
-		return
+	pw.Write(body)
+}
+
+// emlOverriddenHeaders lists the headers buildMessageFromEML always
+// replaces with task/recipient values rather than copying from the
+// template .eml file.
+var emlOverriddenHeaders = map[string]bool{
+	"From": true, "To": true, "Cc": true, "Bcc": true,
+	"Subject": true, "Reply-To": true, "Date": true,
+	"Message-Id": true, "List-Unsubscribe": true, "List-Unsubscribe-Post": true,
+}
+
+// buildMessageFromEML loads a prebuilt RFC 5322 message from mail.EMLPath,
+// substitutes recipient variables into its text/plain and text/html parts,
+// and overrides Sender, To, Cc, Bcc, Subject, Reply-To and Message-ID with
+// the values computed for this send while leaving every other header and
+// MIME part (attachments, inline images) untouched.
+func buildMessageFromEML(mail Mail) []byte {
+	raw, err := ioutil.ReadFile(mail.EMLPath)
+	if err != nil {
+		ErrorLogger.Fatal(err)
 	}
 
-	var client *smtp.Client
+	parsed, err := netmail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		ErrorLogger.Fatal(err)
+	}
 
-	servername := fmt.Sprintf("%s:%d", config.Host, config.Port)
-	auth := smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	body, err := ioutil.ReadAll(parsed.Body)
+	if err != nil {
+		ErrorLogger.Fatal(err)
+	}
 
-	tlsconfig := &tls.Config{
-		InsecureSkipVerify: false,
-		ServerName:         config.Host,
+	var buf bytes.Buffer
+
+	for key, values := range parsed.Header {
+		canonical := textproto.CanonicalMIMEHeaderKey(key)
+		if emlOverriddenHeaders[canonical] {
+			continue
+		}
+		for _, value := range values {
+			buf.WriteString(fmt.Sprintf("%s: %s\r\n", canonical, value))
+		}
 	}
 
-	// Figure out whether SSL or STARTTLS should be used
-	if config.Type == "ssl" || config.Type == "tls" || config.Port == 465 {
-		conn, err := tls.Dial("tcp", servername, tlsconfig)
+	buf.WriteString(fmt.Sprintf("From: %s\r\n", formatHeaderAddress(mail.Sender)))
+	if mail.ReplyTo != "" {
+		buf.WriteString(fmt.Sprintf("Reply-To: %s\r\n", formatHeaderAddress(mail.ReplyTo)))
+	}
+	buf.WriteString(fmt.Sprintf("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n"))
+	if len(mail.To) > 0 {
+		buf.WriteString(fmt.Sprintf("To: %s\r\n", joinAddresses(mail.To)))
+	}
+	if len(mail.Cc) > 0 {
+		buf.WriteString(fmt.Sprintf("Cc: %s\r\n", joinAddresses(mail.Cc)))
+	}
+	buf.WriteString(fmt.Sprintf("Subject: %s\r\n", encodeHeaderText(mail.Subject)))
+	buf.WriteString(fmt.Sprintf("Message-ID: <%s>\r\n", mail.MessageID))
+	writeListUnsubscribeHeaders(&buf, mail)
+	buf.WriteString("\r\n")
+
+	mediaType, params, parseErr := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+
+	switch {
+	case parseErr != nil:
+		// No usable Content-Type: treat the whole body as plain text.
+		rendered, err := renderText(string(body), mail.Recipient)
 		if err != nil {
 			ErrorLogger.Fatal(err)
 		}
+		buf.WriteString(rendered)
+	case strings.HasPrefix(mediaType, "multipart/"):
+		mw := multipart.NewWriter(&buf)
+		if err := mw.SetBoundary(params["boundary"]); err != nil {
+			ErrorLogger.Fatal(err)
+		}
 
-		client, err = smtp.NewClient(conn, config.Host)
+		mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				ErrorLogger.Fatal(err)
+			}
+			partBody, err := ioutil.ReadAll(part)
+			if err != nil {
+				ErrorLogger.Fatal(err)
+			}
+			rewriteMIMEPart(mw, textproto.MIMEHeader(part.Header), partBody, mail.Recipient)
+		}
+		mw.Close()
+	case mediaType == "text/plain" || mediaType == "text/html":
+		rendered, err := renderByMediaType(mediaType, string(body), mail.Recipient)
 		if err != nil {
 			ErrorLogger.Fatal(err)
 		}
+		buf.WriteString(rendered)
+	default:
+		buf.Write(body)
+	}
 
+	return buf.Bytes()
+}
+
+// dialSMTP opens a fresh connection to config and authenticates it,
+// choosing SSL/TLS vs. STARTTLS the same way the previous one-shot
+// sendMail did.
+func dialSMTP(config *Config) (*smtp.Client, error) {
+	servername := fmt.Sprintf("%s:%d", config.Host, config.Port)
+
+	tlsconfig := &tls.Config{
+		InsecureSkipVerify: false,
+		ServerName:         config.Host,
+	}
+
+	var client *smtp.Client
+	var err error
+
+	if config.Type == "ssl" || config.Type == "tls" || config.Port == 465 {
+		conn, dialErr := tls.Dial("tcp", servername, tlsconfig)
+		if dialErr != nil {
+			return nil, dialErr
+		}
+		client, err = smtp.NewClient(conn, config.Host)
 	} else if config.Type == "starttls" {
 		client, err = smtp.Dial(servername)
-		if err != nil {
-			ErrorLogger.Fatal(err)
+		if err == nil {
+			err = client.StartTLS(tlsconfig)
 		}
-		client.StartTLS(tlsconfig)
+	} else {
+		err = fmt.Errorf("unsupported connection type %q", config.Type)
 	}
 
-	if client == nil || err != nil {
-		ErrorLogger.Fatal(err)
+	if err != nil {
+		return nil, err
 	}
 
-	// AUTH
-	if err = client.Auth(auth); err != nil {
-		ErrorLogger.Fatal(err)
+	auth, err := getAuth(config)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			client.Close()
+			return nil, err
+		}
 	}
 
-	// MAIL FROM
-	if err = client.Mail(mail.Sender); err != nil {
-		ErrorLogger.Fatal(err)
+	return client, nil
+}
+
+// rcptAllSequential issues one RCPT TO per address, stopping at the first
+// failure. Used when the server doesn't advertise PIPELINING.
+func rcptAllSequential(client *smtp.Client, addrs []string) error {
+	for _, addr := range addrs {
+		if err := client.Rcpt(addr); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// RCPT TO
-	if err = client.Rcpt(rcptTo); err != nil {
-		ErrorLogger.Fatal(err)
+// sendOne sends msg over an already-connected client to allRecipients,
+// issuing one RCPT TO per address (pipelined when the server advertises
+// PIPELINING) rather than joining them into a single invalid RCPT line.
+// The connection is reset with RSET afterwards so it can be reused for the
+// next mail.
+func sendOne(client *smtp.Client, mail Mail, msg []byte, allRecipients []string) error {
+	if err := client.Mail(mail.Sender); err != nil {
+		return err
+	}
+
+	var rcptErr error
+	if ok, _ := client.Extension("PIPELINING"); ok {
+		rcptErr = pipelinedRcpt(client, allRecipients)
+	} else {
+		rcptErr = rcptAllSequential(client, allRecipients)
+	}
+	if rcptErr != nil {
+		client.Reset()
+		return rcptErr
 	}
 
-	// DATA
 	w, err := client.Data()
 	if err != nil {
-		ErrorLogger.Fatal(err)
+		client.Reset()
+		return err
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		client.Reset()
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
 	}
 
-	_, err = w.Write(msg)
+	return client.Reset()
+}
+
+// sendMail builds mail, optionally stores it under outputFile, and - unless
+// dryrun - sends it over *client, transparently reconnecting (and retrying
+// retryable 4xx failures with exponential backoff, up to config.MaxRetries)
+// using connectFn to establish a new connection when needed. It returns the
+// SMTP status code observed (0 for a connection-level failure) and the
+// error that ended the send (nil when it ultimately succeeded), for the
+// caller to record in the send log.
+func sendMail(config *Config, client **smtp.Client, mail Mail, outputFile string, dryrun bool) (code int, sendErr error) {
+	msg := buildMessage(mail)
+
+	signed, err := signDKIM(config, msg)
 	if err != nil {
-		ErrorLogger.Fatal(err)
+		// The key was already loaded by loadDKIMConfig; a signing failure is
+		// reported as this message's failure rather than killing a worker
+		// pool mid-run, so it's resumable like any other send error.
+		return 0, err
+	}
+	msg = signed
+
+	// [To...,Cc...,Bcc...]: one RCPT TO per address, difference is resembled in mail header
+	allRecipients := append(append(append([]string{}, mail.To...), mail.Cc...), mail.Bcc...)
+	// To/Cc may carry display names ("Name <user@host>"); RCPT TO takes
+	// the bare address only.
+	for i, addr := range allRecipients {
+		if parsed, err := netmail.ParseAddress(addr); err == nil {
+			allRecipients[i] = parsed.Address
+		}
 	}
 
-	client.Quit()
+	if len(allRecipients) == 0 {
+		ErrorLogger.Fatal("the must be at least one recipient in To, Cc, or Bcc!")
+	}
 
-	DebugLogger.Printf("Sent mail to %s", rcptTo)
+	// store mail to output directory
+	if outputFile != "" {
+		i := 0
+		// make sure nothing is overwritten in target destination
+		for _, err := os.Stat(outputFile); err == nil; i++ {
+			outputFile = filepath.Join(filepath.Dir(outputFile), fmt.Sprintf("%d.eml", i))
+			_, err = os.Stat(outputFile)
+		}
+		eml, err := os.Create(outputFile)
+		if err != nil {
+			ErrorLogger.Fatal(err)
+		}
+		eml.Write(msg)
+		eml.Close()
+	}
+
+	if dryrun {
+		fmt.Println(string(msg))
+		DebugLogger.Printf("dryrun: not sending mail to %s", strings.Join(allRecipients, ","))
+		return 250, nil
+	}
+
+	maxAttempts := config.MaxRetries + 1
+	var lastErr error
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if *client == nil {
+			c, err := dialSMTP(config)
+			if err != nil {
+				lastErr = err
+				code, retryable := classifySendErr(err)
+				if !retryable || attempt == maxAttempts {
+					return code, err
+				}
+				backoffSleep(attempt)
+				continue
+			}
+			*client = c
+		}
+
+		err := sendOne(*client, mail, msg, allRecipients)
+		if err == nil {
+			DebugLogger.Printf("Sent mail to %s", strings.Join(allRecipients, ","))
+			return 250, nil
+		}
+
+		lastErr = err
+		code, retryable := classifySendErr(err)
+
+		// A connection-level error invalidates the cached client so the
+		// next attempt reconnects instead of reusing a dead socket.
+		if code == 0 {
+			(*client).Close()
+			*client = nil
+		}
+
+		if !retryable || attempt == maxAttempts {
+			return code, err
+		}
+
+		WarningLogger.Printf(
+			"retryable SMTP error sending to %s (attempt %d/%d): %v",
+			strings.Join(allRecipients, ","), attempt, maxAttempts, err,
+		)
+		backoffSleep(attempt)
+	}
+
+	code, _ = classifySendErr(lastErr)
+	return code, lastErr
 }
 
 func getBody(fileName *string) []byte {
@@ -487,22 +955,6 @@ func getBody(fileName *string) []byte {
 	return template
 }
 
-func processTemplate(template []byte, recipient Recipient) string {
-	var replacements []string
-
-	variables := recipient.Variables
-
-	for i := range variables {
-		variable := variables[i]
-		replacements = append(replacements, variable.Name)
-		replacements = append(replacements, variable.Value)
-	}
-
-	replacer := strings.NewReplacer(replacements...)
-
-	return replacer.Replace(string(template))
-}
-
 func main() {
 
 	flag.Parse()
@@ -524,22 +976,68 @@ func main() {
 
 	task := getTask(&taskFile)
 	recipients := getRecipients(&task.Recipientfile)
-	template := getBody(&task.Bodytemplate)
+
+	var template, htmlTemplate []byte
+	if task.Bodytemplate != "" {
+		template = getBody(&task.Bodytemplate)
+	}
+	if task.HTMLTemplate != "" {
+		htmlTemplate = getBody(&task.HTMLTemplate)
+	}
 
 	var mails []Mail
 
 	for i := range recipients {
 		recipient := recipients[i]
 
+		subject, err := renderText(task.Subject, recipient)
+		if err != nil {
+			ErrorLogger.Fatalf("subject template for %s: %v", recipient.Email, err)
+		}
+
+		replyTo := task.ReplyTo
+		if replyTo != "" {
+			replyTo, err = renderText(task.ReplyTo, recipient)
+			if err != nil {
+				ErrorLogger.Fatalf("replyto template for %s: %v", recipient.Email, err)
+			}
+		}
+
+		toAddr := recipient.Email
+		if recipient.Realname != "" {
+			toAddr = (&netmail.Address{Name: recipient.Realname, Address: recipient.Email}).String()
+		}
+
 		mail := Mail{
-			Sender:      task.Sender,
-			To:          []string{recipient.Email},
-			Cc:          task.Cc,
-			Bcc:         []string{},
-			ReplyTo:     task.ReplyTo,
-			Subject:     task.Subject,
-			Text:        processTemplate(template, recipient),
-			Attachments: recipient.Attachments,
+			Sender:                task.Sender,
+			To:                    []string{toAddr},
+			Cc:                    task.Cc,
+			Bcc:                   []string{},
+			ReplyTo:               replyTo,
+			Subject:               subject,
+			Attachments:           recipient.Attachments,
+			MessageID:             generateMessageID(task.Sender),
+			ListUnsubscribeURL:    task.ListUnsubscribeURL,
+			ListUnsubscribeMailto: task.ListUnsubscribeMailto,
+			PrimaryRecipient:      recipient.Email,
+		}
+
+		if task.EMLTemplate != "" {
+			mail.EMLPath = task.EMLTemplate
+			mail.Recipient = recipient
+		} else {
+			if template != nil {
+				mail.Text, err = renderText(string(template), recipient)
+				if err != nil {
+					ErrorLogger.Fatalf("body template for %s: %v", recipient.Email, err)
+				}
+			}
+			if htmlTemplate != nil {
+				mail.HTML, err = renderHTML(string(htmlTemplate), recipient)
+				if err != nil {
+					ErrorLogger.Fatalf("html template for %s: %v", recipient.Email, err)
+				}
+			}
 		}
 
 		// task can specify attachments for all recipients
@@ -547,13 +1045,45 @@ func main() {
 			mail.Attachments = append(mail.Attachments, task.Attachments...)
 		}
 
+		for j := range mail.Attachments {
+			if mail.Attachments[j].Filename == "" {
+				continue
+			}
+			mail.Attachments[j].Filename, err = renderText(mail.Attachments[j].Filename, recipient)
+			if err != nil {
+				ErrorLogger.Fatalf("attachment filename template for %s: %v", recipient.Email, err)
+			}
+		}
+
 		mails = append(mails, mail)
 
 	}
 
+	if task.EMLTemplate != "" && len(mails) > 0 {
+		// Render the .eml template once, up front, so a bad template or a
+		// missing/corrupt file aborts here rather than mid-run inside
+		// sendMail, after the operator has already confirmed the countdown -
+		// the same guarantee getBody gives Bodytemplate/HTMLTemplate.
+		buildMessageFromEML(mails[0])
+	}
+
+	logPath := sendLogPath(outputDir)
+	if resume {
+		alreadySent := loadResumeSet(logPath)
+		var remaining []Mail
+		for i := range mails {
+			if alreadySent[mails[i].PrimaryRecipient] {
+				DebugLogger.Printf("resume: skipping already-sent recipient %s", mails[i].PrimaryRecipient)
+				continue
+			}
+			remaining = append(remaining, mails[i])
+		}
+		mails = remaining
+	}
+
 	// safety countdown
 	if !dryRun {
-		totalMails := len(recipients) + len(task.Cc) + len(task.Bcc)
+		totalMails := len(mails) + len(task.Cc) + len(task.Bcc)
 		fmt.Printf("\nWARNING: You are going to automatically send %d mail(s):\n\n", totalMails)
 		fmt.Printf("         Subject: \"%s\"\n\n", task.Subject)
 		fmt.Printf("         From: \"%s\"\n", task.Sender)
@@ -576,18 +1106,29 @@ func main() {
 	}
 
 	fmt.Println("Fire!")
+
+	if config.Workers > 1 {
+		// The rate limiter replaces the fixed every-N-mails cooldown, so a
+		// concurrent run doesn't also pause the whole pool periodically.
+		runWorkerPool(config, mails, outputDir, logPath, dryRun, config.Workers, task.MaxPerSecond, task.MaxPerHour)
+		return
+	}
+
+	var client *smtp.Client
 	for i := range mails {
 		outputFile := ""
 		if outputDir != "" {
 			outputFile = filepath.Join(outputDir, fmt.Sprintf("%d.eml", i))
 		}
 
-		sendMail(config, mails[i], outputFile, dryRun)
-		if dryRun {
-			fmt.Printf("> %d of %d mails NOT sent (dry-run)\n", i+1, len(mails))
-		} else {
-			fmt.Printf("> %d of %d mails sent\n", i+1, len(mails))
-		}
+		code, sendErr := sendMail(config, &client, mails[i], outputFile, dryRun)
+		reportSendResult(sendResult{
+			index:     i,
+			recipient: mails[i].PrimaryRecipient,
+			messageID: mails[i].MessageID,
+			code:      code,
+			err:       sendErr,
+		}, len(mails), logPath, dryRun)
 
 		// recovery phase to prevent triggering spam detection of smtp server
 		if !dryRun && (i+1)%cooldown == 0 {
@@ -606,4 +1147,8 @@ func main() {
 
 	}
 
+	if client != nil {
+		client.Quit()
+	}
+
 }
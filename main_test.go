@@ -0,0 +1,18 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHtmlToTextDropsScriptAndStyleContents(t *testing.T) {
+	html := `<style>body{color:red}</style><script>alert(1)</script><p>Hello World</p>`
+	got := htmlToText(html)
+
+	if strings.Contains(got, "color:red") || strings.Contains(got, "alert(1)") {
+		t.Fatalf("htmlToText leaked script/style contents: %q", got)
+	}
+	if got != "Hello World" {
+		t.Errorf("got %q, want %q", got, "Hello World")
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// decodedPart is a flattened leaf part of a parsed MIME tree, keyed by
+// media type, used to assert on buildMessageFromTemplate/buildMessageFromEML
+// output without hand-rolling a full MIME parser in every test.
+type decodedPart struct {
+	mediaType string
+	params    map[string]string
+	header    map[string][]string
+	body      []byte
+}
+
+// parseMIMEMessage parses a full RFC 5322 message and flattens every leaf
+// (non-multipart) body part it contains, recursing into nested multiparts.
+func parseMIMEMessage(t *testing.T, raw []byte) (*mail.Message, []decodedPart) {
+	t.Helper()
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("mail.ReadMessage: %v", err)
+	}
+
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("parse top-level Content-Type %q: %v", msg.Header.Get("Content-Type"), err)
+	}
+
+	var parts []decodedPart
+	collectMIMEParts(t, mediaType, params, body, map[string][]string(msg.Header), &parts)
+	return msg, parts
+}
+
+func collectMIMEParts(t *testing.T, mediaType string, params map[string]string, body []byte, header map[string][]string, out *[]decodedPart) {
+	t.Helper()
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		*out = append(*out, decodedPart{mediaType: mediaType, params: params, header: header, body: body})
+		return
+	}
+
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+		partBody, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+
+		partMediaType, partParams, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			// No parseable Content-Type: treat as an opaque leaf with an
+			// empty mediaType.
+			*out = append(*out, decodedPart{header: map[string][]string(part.Header), body: partBody})
+			continue
+		}
+		collectMIMEParts(t, partMediaType, partParams, partBody, map[string][]string(part.Header), out)
+	}
+}
+
+func findPart(parts []decodedPart, mediaType string) *decodedPart {
+	for i := range parts {
+		if parts[i].mediaType == mediaType {
+			return &parts[i]
+		}
+	}
+	return nil
+}
+
+func TestBuildMessageFromTemplatePlainTextOnly(t *testing.T) {
+	mail := Mail{
+		Sender:    "sender@example.com",
+		To:        []string{"recipient@example.com"},
+		Subject:   "Hello",
+		Text:      "Hello, World!",
+		MessageID: "abc123",
+	}
+
+	msg, parts := parseMIMEMessage(t, buildMessageFromTemplate(mail))
+
+	if got := msg.Header.Get("Subject"); got != "Hello" {
+		t.Errorf("Subject = %q, want %q", got, "Hello")
+	}
+	if got := msg.Header.Get("Message-Id"); got != "<abc123>" {
+		t.Errorf("Message-Id = %q, want %q", got, "<abc123>")
+	}
+
+	part := findPart(parts, "text/plain")
+	if part == nil {
+		t.Fatalf("no text/plain part found in %+v", parts)
+	}
+	if string(part.body) != "Hello, World!" {
+		t.Errorf("text/plain body = %q, want %q", part.body, "Hello, World!")
+	}
+}
+
+func TestBuildMessageFromTemplateHTMLGeneratesTextFallback(t *testing.T) {
+	mail := Mail{
+		Sender:    "sender@example.com",
+		To:        []string{"recipient@example.com"},
+		Subject:   "Hello",
+		HTML:      "<style>p{color:red}</style><p>Hi <b>there</b></p>",
+		MessageID: "abc123",
+	}
+
+	_, parts := parseMIMEMessage(t, buildMessageFromTemplate(mail))
+
+	text := findPart(parts, "text/plain")
+	html := findPart(parts, "text/html")
+	if text == nil || html == nil {
+		t.Fatalf("expected both text/plain and text/html parts, got %+v", parts)
+	}
+	if strings.Contains(string(text.body), "color:red") {
+		t.Errorf("auto-generated text fallback leaked style contents: %q", text.body)
+	}
+	if string(text.body) != "Hi there" {
+		t.Errorf("text/plain fallback = %q, want %q", text.body, "Hi there")
+	}
+}
+
+func TestBuildMessageFromTemplateInlineAttachmentCID(t *testing.T) {
+	imgPath := filepath.Join(t.TempDir(), "logo.png")
+	if err := os.WriteFile(imgPath, []byte("fake-png-bytes"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	mail := Mail{
+		Sender:  "sender@example.com",
+		To:      []string{"recipient@example.com"},
+		Subject: "Hello",
+		HTML:    `<img src="cid:logo123">`,
+		Text:    "see the image",
+		Attachments: []Attachment{
+			{Path: imgPath, Type: "image/png", Inline: true, CID: "logo123", Filename: "logo.png"},
+		},
+		MessageID: "abc123",
+	}
+
+	_, parts := parseMIMEMessage(t, buildMessageFromTemplate(mail))
+
+	var inlinePart *decodedPart
+	for i := range parts {
+		if cid, ok := parts[i].header["Content-Id"]; ok && len(cid) > 0 {
+			inlinePart = &parts[i]
+			break
+		}
+	}
+	if inlinePart == nil {
+		t.Fatalf("no inline Content-ID part found in %+v", parts)
+	}
+	if got := inlinePart.header["Content-Id"][0]; got != "<logo123>" {
+		t.Errorf("Content-ID = %q, want %q", got, "<logo123>")
+	}
+
+	if findPart(parts, "text/plain") == nil || findPart(parts, "text/html") == nil {
+		t.Fatalf("expected alternative text+html parts alongside the inline image, got %+v", parts)
+	}
+}
+
+func TestBuildMessageFromTemplateRegularAttachment(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "report.csv")
+	if err := os.WriteFile(filePath, []byte("a,b,c\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	mail := Mail{
+		Sender:      "sender@example.com",
+		To:          []string{"recipient@example.com"},
+		Subject:     "Hello",
+		Text:        "see attached",
+		Attachments: []Attachment{{Path: filePath, Type: "text/csv", Filename: "report.csv"}},
+		MessageID:   "abc123",
+	}
+
+	_, parts := parseMIMEMessage(t, buildMessageFromTemplate(mail))
+
+	var attachment *decodedPart
+	for i := range parts {
+		if disp, ok := parts[i].header["Content-Disposition"]; ok && strings.HasPrefix(disp[0], "attachment") {
+			attachment = &parts[i]
+			break
+		}
+	}
+	if attachment == nil {
+		t.Fatalf("no attachment part found in %+v", parts)
+	}
+	if !strings.Contains(attachment.header["Content-Disposition"][0], "report.csv") {
+		t.Errorf("Content-Disposition = %q, want it to reference report.csv", attachment.header["Content-Disposition"][0])
+	}
+}
+
+func TestBuildMessageFromEMLRendersVariablesAndOverridesHeaders(t *testing.T) {
+	emlPath := filepath.Join(t.TempDir(), "template.eml")
+	emlContent := "Content-Type: text/plain; charset=\"utf-8\"\r\n" +
+		"From: placeholder@example.com\r\n" +
+		"Subject: placeholder\r\n" +
+		"\r\n" +
+		"Hi {{.Realname}}, your plan is {{default \"free\" .Variables.plan}}.\r\n"
+	if err := os.WriteFile(emlPath, []byte(emlContent), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	mail := Mail{
+		Sender:    "sender@example.com",
+		To:        []string{"recipient@example.com"},
+		Subject:   "Real Subject",
+		EMLPath:   emlPath,
+		Recipient: Recipient{Realname: "Ada", Variables: map[string]string{}},
+		MessageID: "abc123",
+	}
+
+	msg, parts := parseMIMEMessage(t, buildMessageFromEML(mail))
+
+	if got := msg.Header.Get("Subject"); got != "Real Subject" {
+		t.Errorf("Subject = %q, want the overridden value %q", got, "Real Subject")
+	}
+	if got := msg.Header.Get("From"); got != "<sender@example.com>" {
+		t.Errorf("From = %q, want the overridden sender", got)
+	}
+
+	if len(parts) != 1 {
+		t.Fatalf("expected a single rendered part, got %+v", parts)
+	}
+	want := "Hi Ada, your plan is free."
+	if got := strings.TrimRight(string(parts[0].body), "\r\n"); got != want {
+		t.Errorf("rendered body = %q, want %q", got, want)
+	}
+}
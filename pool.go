@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// sendResult is what a pool worker reports back for one mail; results
+// arrive out of submission order, so runWorkerPool buffers them until it
+// can print and log them in order.
+type sendResult struct {
+	index     int
+	recipient string
+	messageID string
+	code      int
+	err       error
+}
+
+// newRateLimiters builds the limiters sendMail jobs must wait on before
+// each send: one for MaxPerSecond, one for MaxPerHour, whichever are set.
+func newRateLimiters(maxPerSecond, maxPerHour float64) []*rate.Limiter {
+	var limiters []*rate.Limiter
+
+	if maxPerSecond > 0 {
+		limiters = append(limiters, rate.NewLimiter(rate.Limit(maxPerSecond), 1))
+	}
+	if maxPerHour > 0 {
+		limiters = append(limiters, rate.NewLimiter(rate.Limit(maxPerHour/3600.0), 1))
+	}
+
+	return limiters
+}
+
+// runWorkerPool sends mails concurrently across workers connections,
+// each honouring maxPerSecond/maxPerHour, and reconnecting its own SMTP
+// connection on error. Progress is still printed and logged in submission
+// order even though sends complete out of order.
+func runWorkerPool(
+	config *Config,
+	mails []Mail,
+	outputDir, logPath string,
+	dryRun bool,
+	workers int,
+	maxPerSecond, maxPerHour float64,
+) {
+	limiters := newRateLimiters(maxPerSecond, maxPerHour)
+	ctx := context.Background()
+
+	jobs := make(chan int)
+	results := make(chan sendResult, len(mails))
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var client *smtp.Client
+			for i := range jobs {
+				for _, limiter := range limiters {
+					limiter.Wait(ctx)
+				}
+
+				outputFile := ""
+				if outputDir != "" {
+					outputFile = filepath.Join(outputDir, fmt.Sprintf("%d.eml", i))
+				}
+
+				code, err := sendMail(config, &client, mails[i], outputFile, dryRun)
+				results <- sendResult{
+					index:     i,
+					recipient: mails[i].PrimaryRecipient,
+					messageID: mails[i].MessageID,
+					code:      code,
+					err:       err,
+				}
+			}
+
+			if client != nil {
+				client.Quit()
+			}
+		}()
+	}
+
+	go func() {
+		for i := range mails {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	drainOrderedResults(results, func(r sendResult) {
+		reportSendResult(r, len(mails), logPath, dryRun)
+	})
+}
+
+// drainOrderedResults reads sendResults from results - which may arrive in
+// any order - until the channel is closed, buffering out-of-order
+// completions and calling report on each one in ascending index order.
+func drainOrderedResults(results <-chan sendResult, report func(sendResult)) {
+	pending := make(map[int]sendResult)
+	next := 0
+	for res := range results {
+		pending[res.index] = res
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			report(r)
+			delete(pending, next)
+			next++
+		}
+	}
+}
+
+// reportSendResult prints progress and appends the send log entry for one
+// mail, exactly as the sequential fire loop does.
+func reportSendResult(r sendResult, total int, logPath string, dryRun bool) {
+	status := "sent"
+	if r.err != nil {
+		status = "failed"
+		WarningLogger.Printf("failed to send to %s: %v", r.recipient, r.err)
+	}
+
+	if !dryRun {
+		appendSendLog(logPath, SendLogEntry{
+			Recipient: r.recipient,
+			MessageID: r.messageID,
+			Status:    status,
+			SMTPCode:  r.code,
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+	}
+
+	switch {
+	case dryRun:
+		fmt.Printf("> %d of %d mails NOT sent (dry-run)\n", r.index+1, total)
+	case r.err != nil:
+		fmt.Printf("> %d of %d mails FAILED: %v\n", r.index+1, total, r.err)
+	default:
+		fmt.Printf("> %d of %d mails sent\n", r.index+1, total)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestDrainOrderedResultsReportsInSubmissionOrder(t *testing.T) {
+	results := make(chan sendResult)
+
+	// Feed completions in a deliberately scrambled order; drainOrderedResults
+	// must still invoke report with index 0, 1, 2, ... in order.
+	go func() {
+		for _, i := range []int{2, 0, 3, 1, 4} {
+			results <- sendResult{index: i}
+		}
+		close(results)
+	}()
+
+	var got []int
+	drainOrderedResults(results, func(r sendResult) {
+		got = append(got, r.index)
+	})
+
+	want := []int{0, 1, 2, 3, 4}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestDrainOrderedResultsConcurrentProducers(t *testing.T) {
+	const n = 50
+	results := make(chan sendResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results <- sendResult{index: i, recipient: fmt.Sprintf("r%d", i)}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var got []int
+	drainOrderedResults(results, func(r sendResult) {
+		got = append(got, r.index)
+	})
+
+	if len(got) != n {
+		t.Fatalf("got %d results, want %d", len(got), n)
+	}
+	for i, idx := range got {
+		if idx != i {
+			t.Fatalf("report order broken at position %d: got index %d", i, idx)
+		}
+	}
+}
+
+func TestRunWorkerPoolDryRunOrdersOutput(t *testing.T) {
+	config := &Config{Host: "smtp.example.com", Port: 587, Type: "starttls", Username: "u", Password: "p"}
+
+	mails := make([]Mail, 20)
+	for i := range mails {
+		mails[i] = Mail{
+			Sender:           "sender@example.com",
+			To:               []string{fmt.Sprintf("r%d@example.com", i)},
+			PrimaryRecipient: fmt.Sprintf("r%d@example.com", i),
+			MessageID:        fmt.Sprintf("id%d", i),
+		}
+	}
+
+	logPath := sendLogPath(t.TempDir())
+
+	stdout := captureStdout(t, func() {
+		runWorkerPool(config, mails, "", logPath, true, 8, 0, 0)
+	})
+
+	lines := progressLines(stdout)
+	if len(lines) != len(mails) {
+		t.Fatalf("got %d progress lines, want %d:\n%s", len(lines), len(mails), stdout)
+	}
+	for i, line := range lines {
+		want := fmt.Sprintf("> %d of %d mails NOT sent (dry-run)", i+1, len(mails))
+		if line != want {
+			t.Fatalf("line %d: got %q, want %q", i, line, want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"net/smtp"
+	"net/textproto"
+	"time"
+)
+
+// pipelinedRcpt issues one RCPT TO per address without waiting for each
+// response in turn (RFC 2920 PIPELINING): all commands are written first,
+// then the responses are read back in submission order. Falls back to the
+// first error encountered, matching client.Rcpt's single-address contract.
+func pipelinedRcpt(client *smtp.Client, addrs []string) error {
+	ids := make([]uint, len(addrs))
+	for i, addr := range addrs {
+		id := client.Text.Next()
+		client.Text.StartRequest(id)
+		err := client.Text.PrintfLine("RCPT TO:<%s>", addr)
+		client.Text.EndRequest(id)
+		if err != nil {
+			return err
+		}
+		ids[i] = id
+	}
+
+	var firstErr error
+	for _, id := range ids {
+		client.Text.StartResponse(id)
+		_, _, err := client.Text.ReadResponse(25)
+		client.Text.EndResponse(id)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// classifySendErr maps an error from dialSMTP/sendOne to the SMTP status
+// code it carries (0 for connection-level errors that never got a code)
+// and whether it's worth retrying: 4xx is a transient server condition,
+// 5xx is permanent, and connection errors are retried via a reconnect.
+func classifySendErr(err error) (code int, retryable bool) {
+	if err == nil {
+		return 250, false
+	}
+
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		return tpErr.Code, tpErr.Code >= 400 && tpErr.Code < 500
+	}
+
+	// No SMTP status code: dial failure, dropped connection, etc.
+	return 0, true
+}
+
+// backoffDelay computes the exponentially increasing delay before attempt
+// number attempt+1, capped at 30s so repeated 4xx responses don't hammer
+// the server but a long run also doesn't stall indefinitely between tries.
+func backoffDelay(attempt int) time.Duration {
+	delay := time.Duration(1<<uint(attempt-1)) * time.Second
+	if delay > 30*time.Second {
+		delay = 30 * time.Second
+	}
+	return delay
+}
+
+// backoffSleep waits the delay backoffDelay computes for attempt.
+func backoffSleep(attempt int) {
+	time.Sleep(backoffDelay(attempt))
+}
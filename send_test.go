@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelayDoublesAndCaps(t *testing.T) {
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 1 * time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{6, 30 * time.Second}, // 32s uncapped, capped to 30s
+		{10, 30 * time.Second},
+	}
+	for _, c := range cases {
+		if got := backoffDelay(c.attempt); got != c.want {
+			t.Errorf("backoffDelay(%d) = %v, want %v", c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestClassifySendErrNil(t *testing.T) {
+	code, retryable := classifySendErr(nil)
+	if code != 250 || retryable {
+		t.Errorf("got (%d, %v), want (250, false)", code, retryable)
+	}
+}
+
+func TestClassifySendErr4xxIsRetryable(t *testing.T) {
+	err := &textproto.Error{Code: 450, Msg: "mailbox busy"}
+	code, retryable := classifySendErr(err)
+	if code != 450 || !retryable {
+		t.Errorf("got (%d, %v), want (450, true)", code, retryable)
+	}
+}
+
+func TestClassifySendErr5xxIsPermanent(t *testing.T) {
+	err := &textproto.Error{Code: 550, Msg: "mailbox unavailable"}
+	code, retryable := classifySendErr(err)
+	if code != 550 || retryable {
+		t.Errorf("got (%d, %v), want (550, false)", code, retryable)
+	}
+}
+
+func TestClassifySendErrConnectionLevelIsRetryable(t *testing.T) {
+	code, retryable := classifySendErr(errors.New("connection reset by peer"))
+	if code != 0 || !retryable {
+		t.Errorf("got (%d, %v), want (0, true)", code, retryable)
+	}
+}
+
+// fakeSMTPServer serves one client connection: it writes the greeting,
+// then for each of numCommands "RCPT TO:<...>" commands it receives
+// (possibly pipelined, i.e. all written before any response is read), it
+// replies with the matching line from responses, in order.
+func fakeSMTPServer(t *testing.T, conn net.Conn, numCommands int, responses []string) {
+	t.Helper()
+
+	w := bufio.NewWriter(conn)
+	r := bufio.NewReader(conn)
+
+	if _, err := w.WriteString("220 fake.example.com ESMTP\r\n"); err != nil {
+		t.Errorf("write greeting: %v", err)
+		return
+	}
+	w.Flush()
+
+	for i := 0; i < numCommands; i++ {
+		if _, err := r.ReadString('\n'); err != nil {
+			t.Errorf("read command %d: %v", i, err)
+			return
+		}
+	}
+
+	for _, line := range responses {
+		if _, err := w.WriteString(line + "\r\n"); err != nil {
+			t.Errorf("write response: %v", err)
+			return
+		}
+	}
+	w.Flush()
+}
+
+func newTestSMTPClient(t *testing.T, numCommands int, responses []string) *smtp.Client {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	go fakeSMTPServer(t, serverConn, numCommands, responses)
+
+	client, err := smtp.NewClient(clientConn, "fake.example.com")
+	if err != nil {
+		t.Fatalf("smtp.NewClient: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestPipelinedRcptAllSucceed(t *testing.T) {
+	addrs := []string{"a@example.com", "b@example.com", "c@example.com"}
+	client := newTestSMTPClient(t, len(addrs), []string{
+		"250 OK a", "250 OK b", "250 OK c",
+	})
+
+	if err := pipelinedRcpt(client, addrs); err != nil {
+		t.Fatalf("pipelinedRcpt: %v", err)
+	}
+}
+
+func TestPipelinedRcptReturnsFirstError(t *testing.T) {
+	addrs := []string{"a@example.com", "b@example.com", "c@example.com"}
+	client := newTestSMTPClient(t, len(addrs), []string{
+		"250 OK a", "550 no such mailbox", "250 OK c",
+	})
+
+	err := pipelinedRcpt(client, addrs)
+	if err == nil {
+		t.Fatal("expected an error from the rejected second recipient")
+	}
+
+	var tpErr *textproto.Error
+	if !errors.As(err, &tpErr) {
+		t.Fatalf("expected a *textproto.Error, got %T: %v", err, err)
+	}
+	if tpErr.Code != 550 {
+		t.Errorf("got code %d, want 550", tpErr.Code)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SendLogEntry is one JSON line in the send log: a durable record of what
+// happened for a single recipient so a run can be resumed with -resume
+// after a crash or an aborted batch.
+type SendLogEntry struct {
+	Recipient string `json:"recipient"`
+	MessageID string `json:"message_id"`
+	Status    string `json:"status"` // "sent" or "failed"
+	SMTPCode  int    `json:"smtp_code"`
+	Timestamp string `json:"timestamp"`
+}
+
+// sendLogPath returns where the send log lives for a given run: inside
+// outputDir when one was given, otherwise a file named after the tool in
+// the current directory.
+func sendLogPath(outputDir string) string {
+	if outputDir != "" {
+		return filepath.Join(outputDir, "sendlog.jsonl")
+	}
+	return Name + ".sendlog.jsonl"
+}
+
+// appendSendLog records the outcome of one send as a new JSON line.
+func appendSendLog(path string, entry SendLogEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		ErrorLogger.Fatal(err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		ErrorLogger.Fatal(err)
+	}
+	defer f.Close()
+
+	f.Write(append(data, '\n'))
+}
+
+// loadResumeSet reads an existing send log and returns the set of
+// recipients already marked "sent", so -resume can skip them. A missing
+// log simply means nothing has been sent yet.
+func loadResumeSet(path string) map[string]bool {
+	sent := make(map[string]bool)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return sent
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var entry SendLogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			WarningLogger.Printf("skipping malformed send log line: %v", err)
+			continue
+		}
+
+		if entry.Status == "sent" {
+			sent[entry.Recipient] = true
+		}
+	}
+
+	return sent
+}
+
+// generateMessageID builds an identifier correlating a send log entry with
+// the mail it describes, scoped to the sender's domain. sender may be a
+// bare address or a "Name <user@host>" form; either way only the domain
+// is used.
+func generateMessageID(sender string) string {
+	address := sender
+	if parsed, err := netmail.ParseAddress(sender); err == nil {
+		address = parsed.Address
+	}
+
+	domain := address
+	if at := strings.LastIndex(address, "@"); at != -1 {
+		domain = address[at+1:]
+	}
+
+	return fmt.Sprintf("%d.%d@%s", time.Now().UnixNano(), rand.Int63(), domain)
+}
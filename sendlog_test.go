@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSendLogResumeRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sendlog.jsonl")
+
+	appendSendLog(path, SendLogEntry{Recipient: "sent1@example.com", MessageID: "m1", Status: "sent", SMTPCode: 250})
+	appendSendLog(path, SendLogEntry{Recipient: "failed@example.com", MessageID: "m2", Status: "failed", SMTPCode: 550})
+	appendSendLog(path, SendLogEntry{Recipient: "sent2@example.com", MessageID: "m3", Status: "sent", SMTPCode: 250})
+
+	resumed := loadResumeSet(path)
+
+	if !resumed["sent1@example.com"] {
+		t.Error("sent1@example.com should be in the resume set")
+	}
+	if !resumed["sent2@example.com"] {
+		t.Error("sent2@example.com should be in the resume set")
+	}
+	if resumed["failed@example.com"] {
+		t.Error("failed@example.com should NOT be in the resume set")
+	}
+	if len(resumed) != 2 {
+		t.Errorf("got %d resume entries, want 2: %v", len(resumed), resumed)
+	}
+}
+
+func TestLoadResumeSetMissingFile(t *testing.T) {
+	resumed := loadResumeSet(filepath.Join(t.TempDir(), "does-not-exist.jsonl"))
+	if len(resumed) != 0 {
+		t.Errorf("got %d entries for a missing log, want 0", len(resumed))
+	}
+}
+
+func TestLoadResumeSetSkipsMalformedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sendlog.jsonl")
+	appendSendLog(path, SendLogEntry{Recipient: "ok@example.com", Status: "sent"})
+
+	// Simulate a malformed line appended after a crash mid-write.
+	appendSendLogRaw(t, path, "{not valid json")
+
+	resumed := loadResumeSet(path)
+	if !resumed["ok@example.com"] {
+		t.Error("ok@example.com should still be in the resume set despite the trailing malformed line")
+	}
+}
+
+func TestGenerateMessageIDUsesDomainFromDisplayNameSender(t *testing.T) {
+	id := generateMessageID("Ada Lovelace <ada@example.com>")
+	wantSuffix := "@example.com"
+	if len(id) < len(wantSuffix) || id[len(id)-len(wantSuffix):] != wantSuffix {
+		t.Errorf("generateMessageID(%q) = %q, want suffix %q", "Ada Lovelace <ada@example.com>", id, wantSuffix)
+	}
+}
+
+func TestGenerateMessageIDUsesDomainFromBareSender(t *testing.T) {
+	id := generateMessageID("ada@example.com")
+	wantSuffix := "@example.com"
+	if len(id) < len(wantSuffix) || id[len(id)-len(wantSuffix):] != wantSuffix {
+		t.Errorf("generateMessageID(%q) = %q, want suffix %q", "ada@example.com", id, wantSuffix)
+	}
+}
+
+// appendSendLogRaw appends a raw line to path, bypassing JSON marshaling,
+// to simulate a malformed or truncated log entry.
+func appendSendLogRaw(t *testing.T, path, line string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// templateFuncMap is shared between the text/template and html/template
+// engines: lower/upper/title for display formatting, default for
+// fallback values, and date for stamping the current time.
+var templateFuncMap = map[string]interface{}{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"title": strings.Title,
+	"default": func(def, val string) string {
+		if val == "" {
+			return def
+		}
+		return val
+	},
+	"date": func(layout string) string {
+		return time.Now().Format(layout)
+	},
+}
+
+// textTemplateCache and htmlTemplateCache hold one compiled template per
+// distinct source string, so a 5000-recipient run parses each Subject,
+// ReplyTo, body and attachment-filename template once rather than once
+// per recipient.
+var (
+	templateCacheMu   sync.Mutex
+	textTemplateCache = map[string]*texttemplate.Template{}
+	htmlTemplateCache = map[string]*htmltemplate.Template{}
+)
+
+// compileText parses source on its first use and returns the cached
+// *template.Template on every later call with the same source.
+func compileText(source string) (*texttemplate.Template, error) {
+	templateCacheMu.Lock()
+	defer templateCacheMu.Unlock()
+
+	if tmpl, ok := textTemplateCache[source]; ok {
+		return tmpl, nil
+	}
+
+	tmpl, err := texttemplate.New("").
+		Funcs(texttemplate.FuncMap(templateFuncMap)).
+		Option("missingkey=zero").
+		Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	textTemplateCache[source] = tmpl
+	return tmpl, nil
+}
+
+// compileHTML is compileText's html/template counterpart.
+func compileHTML(source string) (*htmltemplate.Template, error) {
+	templateCacheMu.Lock()
+	defer templateCacheMu.Unlock()
+
+	if tmpl, ok := htmlTemplateCache[source]; ok {
+		return tmpl, nil
+	}
+
+	tmpl, err := htmltemplate.New("").
+		Funcs(htmltemplate.FuncMap(templateFuncMap)).
+		Option("missingkey=zero").
+		Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlTemplateCache[source] = tmpl
+	return tmpl, nil
+}
+
+// renderText renders a text/template source (Subject, ReplyTo, attachment
+// filenames, and the plain-text body) against recipient, exposed as ".".
+// A missing map key (e.g. an optional Variables entry) renders as the
+// empty string rather than aborting, so {{default "x" .Variables.y}} and
+// {{if .Variables.y}} work for recipients that don't set y.
+func renderText(source string, recipient Recipient) (string, error) {
+	tmpl, err := compileText(source)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, recipient); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// renderByMediaType renders source with renderHTML for a text/html part
+// and renderText for anything else, so callers that only know a MIME
+// media type (EML re-serialization) substitute variables correctly for
+// either body.
+func renderByMediaType(mediaType, source string, recipient Recipient) (string, error) {
+	if mediaType == "text/html" {
+		return renderHTML(source, recipient)
+	}
+	return renderText(source, recipient)
+}
+
+// renderHTML renders an html/template source (the HTML body) against
+// recipient, auto-escaping any recipient-controlled values. As with
+// renderText, a missing Variables key renders as the empty string.
+func renderHTML(source string, recipient Recipient) (string, error) {
+	tmpl, err := compileHTML(source)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, recipient); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestRenderTextMissingVariableDefaultsToEmpty(t *testing.T) {
+	recipient := Recipient{Email: "a@example.com", Variables: map[string]string{}}
+
+	got, err := renderText(`{{default "free" .Variables.plan}}`, recipient)
+	if err != nil {
+		t.Fatalf("renderText: %v", err)
+	}
+	if got != "free" {
+		t.Errorf("got %q, want %q", got, "free")
+	}
+}
+
+func TestRenderTextIfOnMissingVariable(t *testing.T) {
+	recipient := Recipient{Email: "a@example.com", Variables: map[string]string{}}
+
+	got, err := renderText(`{{if .Variables.plan}}paid{{else}}none{{end}}`, recipient)
+	if err != nil {
+		t.Fatalf("renderText: %v", err)
+	}
+	if got != "none" {
+		t.Errorf("got %q, want %q", got, "none")
+	}
+}
+
+func TestRenderTextFuncs(t *testing.T) {
+	recipient := Recipient{Realname: "Ada Lovelace", Email: "ADA@EXAMPLE.COM"}
+
+	got, err := renderText(`{{.Realname | upper}} <{{.Email | lower}}>`, recipient)
+	if err != nil {
+		t.Fatalf("renderText: %v", err)
+	}
+	want := "ADA LOVELACE <ada@example.com>"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRenderHTMLEscapesRecipientData(t *testing.T) {
+	recipient := Recipient{Realname: "<script>alert(1)</script>"}
+
+	got, err := renderHTML(`Hello {{.Realname}}`, recipient)
+	if err != nil {
+		t.Fatalf("renderHTML: %v", err)
+	}
+	if got == "Hello <script>alert(1)</script>" {
+		t.Errorf("html/template did not escape recipient data: %q", got)
+	}
+}
+
+func TestRenderTextInvalidSyntax(t *testing.T) {
+	_, err := renderText(`{{.Unterminated`, Recipient{})
+	if err == nil {
+		t.Fatal("expected an error for malformed template syntax")
+	}
+}